@@ -0,0 +1,207 @@
+package udptransport
+
+import (
+	"io"
+	"sync"
+
+	"github.com/peer-calls/peer-calls/server/servertransport"
+)
+
+// sctpStream is the subset of *sctp.Stream that Transport needs, extracted
+// so it can be wrapped by countingStream for metrics purposes.
+type sctpStream interface {
+	io.ReadWriteCloser
+}
+
+// countingStream wraps an sctpStream, tracking bytes/packets in and out in
+// counters for Transport.Stats(). It also demultiplexes the FIN/FIN_ACK and
+// heartbeat ping/pong control frames away from the application data
+// delivered through Read: once start is called, a single goroutine owns
+// every Read off the underlying stream, replies to an incoming FIN or ping
+// inline, and forwards anything else on to Read's caller. Without this,
+// both the close/heartbeat code and the real consumer of the stream
+// (servertransport.Transport) would race reading the same underlying stream
+// directly.
+type countingStream struct {
+	sctpStream
+
+	counters *connCounters
+
+	startOnce sync.Once
+
+	readCh chan []byte
+	errCh  chan error
+
+	readErr     error
+	readErrOnce sync.Once
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	finAckCh   chan struct{}
+	finAckOnce sync.Once
+
+	pongCh chan struct{}
+}
+
+func newCountingStream(stream sctpStream) *countingStream {
+	return &countingStream{
+		sctpStream: stream,
+		counters:   &connCounters{},
+		readCh:     make(chan []byte, 16),
+		errCh:      make(chan error, 1),
+		stopCh:     make(chan struct{}),
+		finAckCh:   make(chan struct{}),
+		pongCh:     make(chan struct{}, 1),
+	}
+}
+
+// start begins the demux loop. It must be called before anything reads from
+// the countingStream, and must only be called once.
+func (c *countingStream) start() {
+	c.startOnce.Do(func() {
+		go c.demux()
+	})
+}
+
+// stop unblocks demux if it is waiting to forward a frame to a Read call
+// that will never come (e.g. the Transport is being torn down and nothing
+// reads from the stream anymore), and unblocks anything still waiting on
+// finAckCh (see waitFINAck): once the stream is stopped, no FIN_ACK is ever
+// going to arrive. It is idempotent and safe to call even if demux has
+// already exited on its own.
+func (c *countingStream) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+
+	c.finAckOnce.Do(func() {
+		close(c.finAckCh)
+	})
+}
+
+// demux reads frames off the underlying stream one at a time (sctp streams
+// are message-oriented, so a single Read always returns exactly one frame
+// written by the peer), replying to control frames inline and forwarding
+// everything else to readCh for Read to pick up.
+func (c *countingStream) demux() {
+	buf := make([]byte, servertransport.ReceiveMTU)
+
+	for {
+		n, err := c.sctpStream.Read(buf)
+		if n > 0 {
+			c.counters.addIn(n)
+
+			if handled := c.handleControlFrame(buf[:n]); !handled {
+				frame := make([]byte, n)
+				copy(frame, buf[:n])
+
+				select {
+				case c.readCh <- frame:
+				case <-c.stopCh:
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			c.errCh <- err
+			close(c.readCh)
+
+			return
+		}
+	}
+}
+
+// handleControlFrame replies to FIN/FIN_ACK and heartbeat ping/pong control
+// frames inline and reports whether frame was one of them, so demux knows
+// not to forward it as application data.
+func (c *countingStream) handleControlFrame(frame []byte) bool {
+	switch string(frame) {
+	case finControlFrame:
+		if _, err := c.sctpStream.Write([]byte(finAckControlFrame)); err == nil {
+			c.counters.addOut(len(finAckControlFrame))
+		}
+
+		return true
+	case finAckControlFrame:
+		c.finAckOnce.Do(func() {
+			close(c.finAckCh)
+		})
+
+		return true
+	case heartbeatPing:
+		if _, err := c.sctpStream.Write([]byte(heartbeatPong)); err == nil {
+			c.counters.addOut(len(heartbeatPong))
+		}
+
+		return true
+	case heartbeatPong:
+		select {
+		case c.pongCh <- struct{}{}:
+		default:
+			// A pong already arrived and hasn't been consumed yet (e.g. the
+			// previous heartbeat timed out); drop this one.
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Read returns application data frames forwarded by demux. Once the
+// underlying stream ends, readCh is closed and every subsequent Read keeps
+// returning the same terminal error, per the io.Reader contract, rather than
+// blocking on an errCh that is only ever sent to once.
+func (c *countingStream) Read(p []byte) (int, error) {
+	frame, ok := <-c.readCh
+	if !ok {
+		c.readErrOnce.Do(func() {
+			c.readErr = <-c.errCh
+		})
+
+		return 0, c.readErr
+	}
+
+	return copy(p, frame), nil
+}
+
+func (c *countingStream) Write(p []byte) (int, error) {
+	n, err := c.sctpStream.Write(p)
+	if n > 0 {
+		c.counters.addOut(n)
+	}
+
+	return n, err
+}
+
+// countingConn wraps an io.ReadWriteCloser, tracking bytes/packets in and
+// out in counters, for Transport.Stats().
+type countingConn struct {
+	io.ReadWriteCloser
+
+	counters *connCounters
+}
+
+func newCountingConn(conn io.ReadWriteCloser) *countingConn {
+	return &countingConn{ReadWriteCloser: conn, counters: &connCounters{}}
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.counters.addIn(n)
+	}
+
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.counters.addOut(n)
+	}
+
+	return n, err
+}
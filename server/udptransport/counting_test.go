@@ -0,0 +1,178 @@
+package udptransport
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStream is an in-memory, message-oriented sctpStream used by tests: a
+// Write on one end is delivered whole to the next Read on the other end,
+// mirroring how pion/sctp streams behave.
+type fakeStream struct {
+	out chan []byte
+	in  chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newFakeStreamPair() (*fakeStream, *fakeStream) {
+	ab := make(chan []byte, 16)
+	ba := make(chan []byte, 16)
+
+	a := &fakeStream{out: ab, in: ba, closed: make(chan struct{})}
+	b := &fakeStream{out: ba, in: ab, closed: make(chan struct{})}
+
+	return a, b
+}
+
+func (f *fakeStream) Read(p []byte) (int, error) {
+	select {
+	case msg, ok := <-f.in:
+		if !ok {
+			return 0, io.EOF
+		}
+
+		return copy(p, msg), nil
+	case <-f.closed:
+		return 0, io.EOF
+	}
+}
+
+func (f *fakeStream) Write(p []byte) (int, error) {
+	msg := make([]byte, len(p))
+	copy(msg, p)
+
+	select {
+	case f.out <- msg:
+		return len(p), nil
+	case <-f.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (f *fakeStream) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.closed)
+	})
+
+	return nil
+}
+
+var _ sctpStream = (*fakeStream)(nil)
+
+// TestCountingStream_StopUnblocksFINAckWaiters guards against a regression
+// where a goroutine blocked on finAckCh (see Transport.waitFINAck) leaked
+// forever once a close gave up waiting for a FIN_ACK that was never going
+// to arrive: stop must close finAckCh too, not just stopCh, so any pending
+// waiter is released.
+func TestCountingStream_StopUnblocksFINAckWaiters(t *testing.T) {
+	raw, _ := newFakeStreamPair()
+
+	c := newCountingStream(raw)
+	c.start()
+
+	done := make(chan struct{})
+
+	go func() {
+		<-c.finAckCh
+		close(done)
+	}()
+
+	c.stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not unblock a waiter on finAckCh")
+	}
+}
+
+// TestCountingStream_ReadAfterEOFKeepsReturningError guards against a
+// regression where a countingStream.Read call after the underlying stream
+// ended would return the terminal error once and then block forever on
+// every call after that, instead of keeping to the io.Reader contract of
+// returning the same error on every subsequent call.
+func TestCountingStream_ReadAfterEOFKeepsReturningError(t *testing.T) {
+	raw, _ := newFakeStreamPair()
+
+	c := newCountingStream(raw)
+	c.start()
+
+	if err := raw.Close(); err != nil {
+		t.Fatalf("close stream: %v", err)
+	}
+
+	buf := make([]byte, 16)
+
+	for i := 0; i < 3; i++ {
+		done := make(chan struct{})
+
+		var err error
+
+		go func() {
+			_, err = c.Read(buf)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Read call %d blocked instead of returning the terminal error", i)
+		}
+
+		if err != io.EOF {
+			t.Fatalf("Read call %d: got error %v, want io.EOF", i, err)
+		}
+	}
+}
+
+// TestCountingStream_StopUnblocksDemux guards against a regression where
+// demux's send to readCh had no way to be cancelled: if the consumer
+// stopped calling Read before the stream was exhausted and the peer kept
+// sending, demux would block forever on that send once readCh's buffer
+// filled, leaking the goroutine for the life of the process.
+func TestCountingStream_StopUnblocksDemux(t *testing.T) {
+	raw, peer := newFakeStreamPair()
+
+	c := newCountingStream(raw)
+	c.start()
+
+	// Fill readCh's buffer without ever calling c.Read, then send one more
+	// frame so demux is blocked trying to forward it.
+	for i := 0; i < cap(c.readCh)+1; i++ {
+		if _, err := peer.Write([]byte("data")); err != nil {
+			t.Fatalf("write data frame %d: %v", i, err)
+		}
+	}
+
+	// Give demux a chance to drain the fakeStream and block on the send.
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+
+	go func() {
+		c.stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not return")
+	}
+
+	// demux should have exited instead of leaking, dropping the frame it was
+	// blocked on: once the buffered frames are drained, nothing else arrives.
+	for i := 0; i < cap(c.readCh); i++ {
+		<-c.readCh
+	}
+
+	select {
+	case <-c.readCh:
+		t.Fatal("demux did not exit after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
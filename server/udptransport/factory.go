@@ -24,13 +24,31 @@ type Factory struct {
 	pendingTransports map[string]*Request
 	mu                sync.Mutex
 	wg                *sync.WaitGroup
+	params            FactoryParams
+	failures          failureCounters
 }
 
+// NewFactory creates a Factory. params is optional and defaults to
+// DefaultFactoryParams when omitted, so existing callers keep compiling
+// unchanged after params was introduced. Passing more than one params is a
+// programmer error and panics, rather than silently discarding all but the
+// first.
 func NewFactory(
 	log logger.Logger,
 	wg *sync.WaitGroup,
 	stringMux *stringmux.StringMux,
+	params ...FactoryParams,
 ) *Factory {
+	if len(params) > 1 {
+		panic("udptransport: NewFactory takes at most one FactoryParams")
+	}
+
+	var p FactoryParams
+
+	if len(params) > 0 {
+		p = params[0]
+	}
+
 	return &Factory{
 		log:               log.WithNamespaceAppended("transport_factory"),
 		stringMux:         stringMux,
@@ -38,9 +56,33 @@ func NewFactory(
 		transports:        map[string]*Transport{},
 		pendingTransports: map[string]*Request{},
 		wg:                wg,
+		params:            p.withDefaults(),
 	}
 }
 
+// Stats returns a snapshot of how many transports are open and pending, and
+// how many creation attempts have failed, classified by stage.
+func (t *Factory) Stats() FactoryStats {
+	t.mu.Lock()
+	open := len(t.transports)
+	pending := len(t.pendingTransports)
+	t.mu.Unlock()
+
+	return FactoryStats{
+		Open:     open,
+		Pending:  pending,
+		Failures: t.failures.snapshot(),
+	}
+}
+
+// recordFailure updates both the Factory's own Stats() counters and the
+// configured MetricsSink for a transport creation attempt that failed at
+// stage.
+func (t *Factory) recordFailure(stage FailureStage) {
+	t.failures.record(stage)
+	t.params.MetricsSink.TransportCreateFailed(stage)
+}
+
 func (t *Factory) addPendingTransport(req *Request) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -82,9 +124,20 @@ func (t *Factory) removePendingRequestWhenDone(req *Request) {
 // Wait method. The Wait() method must be called and the error must be checked
 // and handled.
 func (t *Factory) AcceptTransport() *Request {
+	return t.AcceptTransportContext(context.Background())
+}
+
+// AcceptTransportContext is the same as AcceptTransport, but bounds
+// transport establishment (DTLS handshake, inner mux setup and the SCTP
+// handshake) by ctx: canceling ctx aborts the in-progress Request the same
+// way Request.Cancel does. ctx is only consulted once stringmux has accepted
+// a conn; the wait in stringmux accept itself is not bounded by ctx.
+func (t *Factory) AcceptTransportContext(ctx context.Context) *Request {
 	conn, err := t.stringMux.AcceptConn()
 	if err != nil {
-		req := NewRequest(context.Background(), "")
+		t.recordFailure(FailureStageStringMuxAccept)
+
+		req := NewRequest(ctx, "")
 		req.set(nil, errors.Annotate(err, "accept transport"))
 
 		return req
@@ -92,7 +145,7 @@ func (t *Factory) AcceptTransport() *Request {
 
 	streamID := conn.StreamID()
 
-	req := NewRequest(context.Background(), streamID)
+	req := NewRequest(ctx, streamID)
 
 	if err := t.addPendingTransport(req); err != nil {
 		req.set(nil, errors.Annotatef(err, "accept: promise or transport already exists: %s", streamID))
@@ -109,12 +162,22 @@ func (t *Factory) createTransportAsync(req *Request, conn stringmux.Conn, server
 	raddr := conn.RemoteAddr()
 	streamID := conn.StreamID()
 
+	securedConn, err := secureConn(req.Context(), t.params, conn, server)
+	if err != nil {
+		t.recordFailure(FailureStageDTLSHandshake)
+
+		conn.Close()
+		req.set(nil, errors.Annotatef(err, "securing conn for raddr: %s %s", raddr, streamID))
+
+		return
+	}
+
 	readChanSize := 100
 
 	// This can be optimized in the future since a StringMux has a minimal
 	// overhead of 3 bytes, and only a single bit is needed.
 	localMux := stringmux.New(stringmux.Params{
-		Conn:           conn,
+		Conn:           securedConn,
 		Log:            t.log,
 		MTU:            uint32(servertransport.ReceiveMTU),
 		ReadChanSize:   readChanSize,
@@ -148,6 +211,8 @@ func (t *Factory) createTransportAsync(req *Request, conn stringmux.Conn, server
 		"m": {},
 	})
 	if err != nil {
+		t.recordFailure(FailureStageInnerMuxSetup)
+
 		localMux.Close()
 		req.set(nil, errors.Annotatef(err, "creating 's' and 'r' conns for raddr: %s %s", raddr, streamID))
 
@@ -286,6 +351,8 @@ func (t *Factory) createTransport(
 	}
 
 	if err != nil {
+		t.recordFailure(FailureStageSCTPHandshake)
+
 		return nil, errors.Annotatef(err, "creating sctp association for raddr: %s %s", raddr, streamID)
 	}
 
@@ -295,6 +362,8 @@ func (t *Factory) createTransport(
 
 	metadataStream, err := association.OpenStream(0, sctp.PayloadTypeWebRTCBinary)
 	if err != nil {
+		t.recordFailure(FailureStageStreamOpen)
+
 		association.Close()
 
 		return nil, errors.Annotatef(err, "creating metadata sctp stream for raddr: %s %s", raddr, streamID)
@@ -302,25 +371,44 @@ func (t *Factory) createTransport(
 
 	dataStream, err := association.OpenStream(1, sctp.PayloadTypeWebRTCBinary)
 	if err != nil {
+		t.recordFailure(FailureStageStreamOpen)
+
 		metadataStream.Close()
 		association.Close()
 
 		return nil, errors.Annotatef(err, "creating data sctp stream for raddr: %s %s", raddr, streamID)
 	}
 
-	transport := servertransport.NewTransport(t.log, mediaConn, dataStream, metadataStream)
+	countingMediaConn := newCountingConn(mediaConn)
+	countingMetadataStream := newCountingStream(metadataStream)
+	countingDataStream := newCountingStream(dataStream)
+
+	// Start demuxing the metadata/data streams before anything reads from
+	// them, so control frames (FIN/FIN_ACK, heartbeat ping/pong) are handled
+	// inline instead of racing with servertransport.Transport's own reads.
+	countingMetadataStream.start()
+	countingDataStream.start()
+
+	transport := servertransport.NewTransport(t.log, countingMediaConn, countingDataStream, countingMetadataStream)
 
 	streamTransport := &Transport{
-		Transport:   transport,
-		StreamID:    streamID,
-		association: association,
-		stringMux:   localMux,
+		Transport:      transport,
+		StreamID:       streamID,
+		log:            t.log,
+		association:    association,
+		stringMux:      localMux,
+		metadataStream: countingMetadataStream,
+		dataStream:     countingDataStream,
+		mediaConn:      countingMediaConn,
+		stopHeartbeat:  make(chan struct{}),
 	}
 
 	t.mu.Lock()
 	t.transports[streamID] = streamTransport
 	t.mu.Unlock()
 
+	t.params.MetricsSink.TransportOpened(streamID)
+
 	t.wg.Add(1)
 
 	go func() {
@@ -331,30 +419,71 @@ func (t *Factory) createTransport(
 		defer t.mu.Unlock()
 
 		delete(t.transports, streamID)
+
+		t.params.MetricsSink.TransportClosed(streamID)
+	}()
+
+	t.wg.Add(1)
+
+	go func() {
+		defer t.wg.Done()
+
+		streamTransport.runHeartbeat(t.params, func() {
+			t.closeDeadTransport(streamID)
+		})
 	}()
 
 	return streamTransport, nil
 }
 
+// CloseTransport gracefully closes the Transport for streamID, if one
+// exists, and blocks until it is done closing.
 func (t *Factory) CloseTransport(streamID string) {
+	t.closeTransport(streamID, (*Transport).CloseAsync)
+}
+
+// closeDeadTransport is like CloseTransport, but is used when a missed
+// heartbeat has declared the peer dead: it closes through closeAsyncDead
+// instead of CloseAsync, so the close doesn't pay the full finLinger
+// waiting on a FIN_ACK the dead peer will never send.
+func (t *Factory) closeDeadTransport(streamID string) {
+	t.closeTransport(streamID, (*Transport).closeAsyncDead)
+}
+
+func (t *Factory) closeTransport(streamID string, closeAsync func(*Transport, func(error))) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 
 	if req, ok := t.pendingTransports[streamID]; ok {
 		// Cancel the pending request.
 		req.Cancel()
+		t.mu.Unlock()
 
 		// Wait for pending request to settle.
 		<-req.Done()
+
+		t.mu.Lock()
+	}
+
+	transport, ok := t.transports[streamID]
+	t.mu.Unlock()
+
+	if !ok {
+		return
 	}
 
-	if transport, ok := t.transports[streamID]; ok {
-		if err := transport.Close(); err != nil {
+	done := make(chan struct{})
+
+	closeAsync(transport, func(err error) {
+		if err != nil {
 			t.log.Error("Close transport", errors.Trace(err), logger.Ctx{
 				"stream_id": streamID,
 			})
 		}
-	}
+
+		close(done)
+	})
+
+	<-done
 }
 
 // NewTransport returns a Request. This promise can be either canceled
@@ -362,7 +491,14 @@ func (t *Factory) CloseTransport(streamID string) {
 // method. The Wait() method must be called and the error must be checked and
 // handled.
 func (t *Factory) NewTransport(streamID string) *Request {
-	req := NewRequest(context.Background(), streamID)
+	return t.NewTransportContext(context.Background(), streamID)
+}
+
+// NewTransportContext is the same as NewTransport, but bounds transport
+// establishment by ctx: canceling ctx aborts the in-progress Request the
+// same way Request.Cancel does.
+func (t *Factory) NewTransportContext(ctx context.Context, streamID string) *Request {
+	req := NewRequest(ctx, streamID)
 
 	if err := t.addPendingTransport(req); err != nil {
 		req.set(nil, errors.Annotatef(err, "new: promise or transport already exists: %s", streamID))
@@ -382,14 +518,43 @@ func (t *Factory) NewTransport(streamID string) *Request {
 	return req
 }
 
+// Close gracefully closes all open transports, fanning the closes out
+// concurrently via Transport.CloseAsync rather than closing them one by one.
 func (t *Factory) Close() error {
 	t.mu.Lock()
-	defer t.mu.Unlock()
+
+	transports := make([]*Transport, 0, len(t.transports))
 
 	for streamID, transport := range t.transports {
-		transport.Close()
+		transports = append(transports, transport)
 		delete(t.transports, streamID)
 	}
 
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(transports))
+
+	for i, transport := range transports {
+		wg.Add(1)
+
+		i, transport := i, transport
+
+		transport.CloseAsync(func(err error) {
+			defer wg.Done()
+
+			errs[i] = err
+		})
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return errors.Annotate(err, "close factory")
+		}
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,90 @@
+package udptransport
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/peer-calls/peer-calls/server/logger"
+)
+
+// heartbeatPing and heartbeatPong are written on the metadata SCTP stream to
+// probe whether the remote peer is still alive. Like the FIN control
+// frames, they are distinct enough from regular traffic that a peer that
+// does not understand them simply never replies, which just surfaces as a
+// missed heartbeat.
+const (
+	heartbeatPing = "\x00PC_PING\x00"
+	heartbeatPong = "\x00PC_PONG\x00"
+)
+
+// runHeartbeat periodically probes the Transport's metadata stream and
+// invokes onDead once params.MaxMissedHeartbeats consecutive probes fail to
+// get a reply in time. It returns when the Transport is closed.
+func (t *Transport) runHeartbeat(params FactoryParams, onDead func()) {
+	ticker := time.NewTicker(params.HeartbeatInterval)
+	defer ticker.Stop()
+
+	missed := 0
+
+	for {
+		select {
+		case <-t.stopHeartbeat:
+			return
+		case <-ticker.C:
+			rtt, err := t.sendHeartbeat(params.HeartbeatTimeout)
+			if err != nil {
+				missed++
+
+				t.log.Warn("Missed heartbeat", logger.Ctx{
+					"stream_id": t.StreamID,
+					"missed":    missed,
+					"error":     err,
+				})
+
+				if missed >= params.MaxMissedHeartbeats {
+					t.log.Error("Too many missed heartbeats, closing transport",
+						errors.Errorf("missed %d consecutive heartbeats", missed),
+						logger.Ctx{"stream_id": t.StreamID},
+					)
+
+					onDead()
+
+					return
+				}
+
+				continue
+			}
+
+			missed = 0
+
+			t.heartbeatMu.Lock()
+			t.rtt = rtt
+			t.lastHeartbeat = time.Now()
+			t.heartbeatMu.Unlock()
+		}
+	}
+}
+
+// sendHeartbeat writes a ping frame on the metadata stream and waits up to
+// timeout for the stream's demux loop (see countingStream.handleControlFrame)
+// to observe a pong, returning the measured round-trip time. Pongs are not
+// matched to pings: a late pong left over from an earlier, timed-out ping is
+// taken as the reply to this one.
+func (t *Transport) sendHeartbeat(timeout time.Duration) (time.Duration, error) {
+	if t.metadataStream == nil {
+		return 0, errors.New("no metadata stream")
+	}
+
+	start := time.Now()
+
+	if _, err := t.metadataStream.Write([]byte(heartbeatPing)); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	select {
+	case <-t.metadataStream.pongCh:
+		return time.Since(start), nil
+	case <-time.After(timeout):
+		return 0, errors.Errorf("heartbeat timed out after %s", timeout)
+	}
+}
@@ -0,0 +1,26 @@
+package udptransport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountingStream_RespondsToHeartbeatPing(t *testing.T) {
+	rawA, rawB := newFakeStreamPair()
+
+	a := newCountingStream(rawA)
+	b := newCountingStream(rawB)
+
+	a.start()
+	b.start()
+
+	if _, err := a.Write([]byte(heartbeatPing)); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+
+	select {
+	case <-a.pongCh:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for heartbeat pong in response to ping")
+	}
+}
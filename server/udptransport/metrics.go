@@ -0,0 +1,144 @@
+package udptransport
+
+import "sync/atomic"
+
+// FailureStage classifies the stage at which a transport creation attempt
+// failed, so operators can tell a flaky network apart from a misbehaving
+// peer.
+type FailureStage string
+
+const (
+	FailureStageStringMuxAccept FailureStage = "stringmux_accept"
+	FailureStageDTLSHandshake   FailureStage = "dtls_handshake"
+	FailureStageInnerMuxSetup   FailureStage = "inner_mux_setup"
+	FailureStageSCTPHandshake   FailureStage = "sctp_handshake"
+	FailureStageStreamOpen      FailureStage = "stream_open"
+)
+
+// MetricsSink receives Factory/Transport lifecycle events as they happen.
+// Implementations must not block, since methods are called synchronously
+// from Factory goroutines.
+type MetricsSink interface {
+	// TransportOpened is called once a Transport has been fully established.
+	TransportOpened(streamID string)
+
+	// TransportClosed is called once a Transport has been removed from the
+	// Factory, whether it closed gracefully or abruptly.
+	TransportClosed(streamID string)
+
+	// TransportCreateFailed is called when transport establishment fails,
+	// tagged with the stage it failed at.
+	TransportCreateFailed(stage FailureStage)
+}
+
+// NoopMetricsSink discards every event. It is the MetricsSink used by a
+// Factory that was not given one explicitly.
+type NoopMetricsSink struct{}
+
+func (NoopMetricsSink) TransportOpened(string)            {}
+func (NoopMetricsSink) TransportClosed(string)            {}
+func (NoopMetricsSink) TransportCreateFailed(FailureStage) {}
+
+// FactoryStats is a snapshot of a Factory's bookkeeping, suitable for
+// exposing via a Prometheus-style exporter.
+type FactoryStats struct {
+	// Open is the number of fully established transports.
+	Open int
+
+	// Pending is the number of transports currently being established.
+	Pending int
+
+	// Failures counts creation attempts that failed, by stage.
+	Failures FailureStats
+}
+
+// FailureStats counts transport creation failures by the stage at which
+// they occurred.
+type FailureStats struct {
+	StringMuxAccept int64
+	DTLSHandshake   int64
+	InnerMuxSetup   int64
+	SCTPHandshake   int64
+	StreamOpen      int64
+}
+
+// failureCounters holds the atomic counters backing FailureStats.
+type failureCounters struct {
+	stringMuxAccept int64
+	dtlsHandshake   int64
+	innerMuxSetup   int64
+	sctpHandshake   int64
+	streamOpen      int64
+}
+
+func (c *failureCounters) record(stage FailureStage) {
+	switch stage {
+	case FailureStageStringMuxAccept:
+		atomic.AddInt64(&c.stringMuxAccept, 1)
+	case FailureStageDTLSHandshake:
+		atomic.AddInt64(&c.dtlsHandshake, 1)
+	case FailureStageInnerMuxSetup:
+		atomic.AddInt64(&c.innerMuxSetup, 1)
+	case FailureStageSCTPHandshake:
+		atomic.AddInt64(&c.sctpHandshake, 1)
+	case FailureStageStreamOpen:
+		atomic.AddInt64(&c.streamOpen, 1)
+	}
+}
+
+func (c *failureCounters) snapshot() FailureStats {
+	return FailureStats{
+		StringMuxAccept: atomic.LoadInt64(&c.stringMuxAccept),
+		DTLSHandshake:   atomic.LoadInt64(&c.dtlsHandshake),
+		InnerMuxSetup:   atomic.LoadInt64(&c.innerMuxSetup),
+		SCTPHandshake:   atomic.LoadInt64(&c.sctpHandshake),
+		StreamOpen:      atomic.LoadInt64(&c.streamOpen),
+	}
+}
+
+// ConnStats holds byte/packet counters for one conn or SCTP stream.
+//
+// This intentionally does not include SCTP-level retransmit counts or
+// congestion window size: pion/sctp does not expose either through its
+// public API, so there is nothing for connCounters to read them from. If
+// that changes upstream, add them here rather than working around it.
+type ConnStats struct {
+	BytesIn    int64
+	BytesOut   int64
+	PacketsIn  int64
+	PacketsOut int64
+}
+
+// connCounters are the atomic counters backing ConnStats.
+type connCounters struct {
+	bytesIn    int64
+	bytesOut   int64
+	packetsIn  int64
+	packetsOut int64
+}
+
+func (c *connCounters) addIn(n int) {
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	atomic.AddInt64(&c.packetsIn, 1)
+}
+
+func (c *connCounters) addOut(n int) {
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	atomic.AddInt64(&c.packetsOut, 1)
+}
+
+func (c *connCounters) snapshot() ConnStats {
+	return ConnStats{
+		BytesIn:    atomic.LoadInt64(&c.bytesIn),
+		BytesOut:   atomic.LoadInt64(&c.bytesOut),
+		PacketsIn:  atomic.LoadInt64(&c.packetsIn),
+		PacketsOut: atomic.LoadInt64(&c.packetsOut),
+	}
+}
+
+// TransportStats holds SCTP-derived counters for a single Transport.
+type TransportStats struct {
+	Media    ConnStats
+	Data     ConnStats
+	Metadata ConnStats
+}
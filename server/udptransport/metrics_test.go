@@ -0,0 +1,66 @@
+package udptransport
+
+import "testing"
+
+// fakeMetricsSink records every event passed to it, for tests.
+type fakeMetricsSink struct {
+	opened []string
+	closed []string
+	failed []FailureStage
+}
+
+func (s *fakeMetricsSink) TransportOpened(streamID string) {
+	s.opened = append(s.opened, streamID)
+}
+
+func (s *fakeMetricsSink) TransportClosed(streamID string) {
+	s.closed = append(s.closed, streamID)
+}
+
+func (s *fakeMetricsSink) TransportCreateFailed(stage FailureStage) {
+	s.failed = append(s.failed, stage)
+}
+
+var _ MetricsSink = (*fakeMetricsSink)(nil)
+
+// TestFactory_RecordFailureNotifiesMetricsSink guards against a regression
+// where Factory recorded creation failures in its own Stats() counters but
+// never told the configured MetricsSink about them: the first cut of this
+// feature did exactly that, and it was only caught by a self-review, not a
+// test. This exercises recordFailure directly, since constructing a Factory
+// that can actually run AcceptTransportContext/NewTransportContext needs a
+// real stringmux.StringMux and SCTP association, which is beyond what a
+// unit test in this package can fake; TransportOpened/TransportClosed,
+// which only fire from deep inside a successful createTransport, aren't
+// covered here for the same reason.
+func TestFactory_RecordFailureNotifiesMetricsSink(t *testing.T) {
+	stages := []struct {
+		stage FailureStage
+		want  FailureStats
+	}{
+		{FailureStageStringMuxAccept, FailureStats{StringMuxAccept: 1}},
+		{FailureStageDTLSHandshake, FailureStats{DTLSHandshake: 1}},
+		{FailureStageInnerMuxSetup, FailureStats{InnerMuxSetup: 1}},
+		{FailureStageSCTPHandshake, FailureStats{SCTPHandshake: 1}},
+		{FailureStageStreamOpen, FailureStats{StreamOpen: 1}},
+	}
+
+	for _, tc := range stages {
+		tc := tc
+
+		t.Run(string(tc.stage), func(t *testing.T) {
+			sink := &fakeMetricsSink{}
+			f := &Factory{params: FactoryParams{MetricsSink: sink}.withDefaults()}
+
+			f.recordFailure(tc.stage)
+
+			if got := f.Stats().Failures; got != tc.want {
+				t.Fatalf("Stats().Failures = %+v, want %+v", got, tc.want)
+			}
+
+			if want := []FailureStage{tc.stage}; len(sink.failed) != 1 || sink.failed[0] != want[0] {
+				t.Fatalf("MetricsSink.TransportCreateFailed calls = %v, want %v", sink.failed, want)
+			}
+		})
+	}
+}
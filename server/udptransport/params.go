@@ -0,0 +1,58 @@
+package udptransport
+
+import "time"
+
+// FactoryParams configures optional behaviour of a Factory, such as the SCTP
+// keepalive used for dead-peer detection. The zero value is equivalent to
+// DefaultFactoryParams.
+type FactoryParams struct {
+	// HeartbeatInterval is how often a heartbeat frame is written on a
+	// Transport's metadata SCTP stream. Defaults to 5 seconds.
+	HeartbeatInterval time.Duration
+
+	// HeartbeatTimeout bounds how long a heartbeat reply is waited for
+	// before it is counted as missed. Defaults to 2 seconds.
+	HeartbeatTimeout time.Duration
+
+	// MaxMissedHeartbeats is how many consecutive missed heartbeat replies
+	// are tolerated before a Transport is considered dead and closed.
+	// Defaults to 3.
+	MaxMissedHeartbeats int
+
+	// MetricsSink receives Factory/Transport lifecycle events. Defaults to
+	// NoopMetricsSink, which discards them.
+	MetricsSink MetricsSink
+
+	// SecurityConfig, when set, requires a DTLS handshake over the outer
+	// stringmux conn before the inner mux and SCTP association are set up.
+	// Defaults to nil, i.e. cleartext.
+	SecurityConfig *SecurityConfig
+}
+
+// DefaultFactoryParams is the FactoryParams used by NewFactory when none are
+// given.
+var DefaultFactoryParams = FactoryParams{
+	HeartbeatInterval:   5 * time.Second,
+	HeartbeatTimeout:    2 * time.Second,
+	MaxMissedHeartbeats: 3,
+}
+
+func (p FactoryParams) withDefaults() FactoryParams {
+	if p.HeartbeatInterval <= 0 {
+		p.HeartbeatInterval = DefaultFactoryParams.HeartbeatInterval
+	}
+
+	if p.HeartbeatTimeout <= 0 {
+		p.HeartbeatTimeout = DefaultFactoryParams.HeartbeatTimeout
+	}
+
+	if p.MaxMissedHeartbeats <= 0 {
+		p.MaxMissedHeartbeats = DefaultFactoryParams.MaxMissedHeartbeats
+	}
+
+	if p.MetricsSink == nil {
+		p.MetricsSink = NoopMetricsSink{}
+	}
+
+	return p
+}
@@ -0,0 +1,90 @@
+package udptransport
+
+import (
+	"context"
+	"sync"
+)
+
+// Request represents a pending asynchronous Transport creation, either from
+// AcceptTransport or NewTransport. It can be waited on via Wait, or aborted
+// via Cancel.
+type Request struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	streamID string
+
+	done chan struct{}
+	once sync.Once
+
+	mu        sync.Mutex
+	transport *Transport
+	err       error
+}
+
+// NewRequest creates a new Request bound to ctx. Canceling ctx, or calling
+// Cancel, cancels the Request's Context; it does not settle the Request
+// itself. Done and Wait unblock only once the Request is settled, which the
+// Factory does with an error after it observes the canceled Context.
+func NewRequest(ctx context.Context, streamID string) *Request {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Request{
+		ctx:      ctx,
+		cancel:   cancel,
+		streamID: streamID,
+		done:     make(chan struct{}),
+	}
+}
+
+// StreamID returns the stream ID this Request is for.
+func (r *Request) StreamID() string {
+	return r.streamID
+}
+
+// Context returns the context this Request was created with.
+func (r *Request) Context() context.Context {
+	return r.ctx
+}
+
+// Cancel cancels the Request's context.
+func (r *Request) Cancel() {
+	r.cancel()
+}
+
+// Done is closed once the Request has been settled, either with a Transport
+// or an error.
+func (r *Request) Done() <-chan struct{} {
+	return r.done
+}
+
+// set settles the Request with either a Transport, or an error. It returns
+// false when the Request has already been settled.
+func (r *Request) set(transport *Transport, err error) bool {
+	ok := false
+
+	r.once.Do(func() {
+		ok = true
+
+		r.mu.Lock()
+		r.transport = transport
+		r.err = err
+		r.mu.Unlock()
+
+		r.cancel()
+		close(r.done)
+	})
+
+	return ok
+}
+
+// Wait blocks until the Request is settled and returns the resulting
+// Transport, or an error.
+func (r *Request) Wait() (*Transport, error) {
+	<-r.done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.transport, r.err
+}
@@ -0,0 +1,90 @@
+package udptransport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/juju/errors"
+	"github.com/peer-calls/peer-calls/server/stringmux"
+	"github.com/pion/dtls/v2"
+)
+
+// SecurityConfig enables a DTLS handshake over the outer stringmux conn,
+// before the inner mux (and the SCTP association running over it) is set
+// up. This is for server-to-server transports that cross untrusted
+// networks; a nil SecurityConfig (the default) keeps the current cleartext
+// behavior, which is fine on a trusted LAN.
+type SecurityConfig struct {
+	// Certificates are offered during the DTLS handshake.
+	Certificates []tls.Certificate
+
+	// PSK and PSKIdentityHint configure pre-shared key mode instead of
+	// certificates, when PSK is set.
+	PSK             dtls.PSKCallback
+	PSKIdentityHint []byte
+
+	// InsecureSkipVerify disables verification of the peer's certificate.
+	// Only useful for testing.
+	InsecureSkipVerify bool
+}
+
+func (c *SecurityConfig) dtlsConfig() *dtls.Config {
+	return &dtls.Config{
+		Certificates:       c.Certificates,
+		PSK:                c.PSK,
+		PSKIdentityHint:    c.PSKIdentityHint,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+}
+
+// handshake performs the DTLS handshake over conn, with server determining
+// whether this side plays the DTLS server (AcceptTransport) or client
+// (NewTransport) role.
+func (c *SecurityConfig) handshake(ctx context.Context, conn net.Conn, server bool) (net.Conn, error) {
+	config := c.dtlsConfig()
+
+	if server {
+		return dtls.ServerWithContext(ctx, conn, config)
+	}
+
+	return dtls.ClientWithContext(ctx, conn, config)
+}
+
+// secureStringMuxConn adapts a DTLS net.Conn back into a stringmux.Conn, so
+// it can be handed to stringmux.New the same way the cleartext outer conn
+// would be.
+type secureStringMuxConn struct {
+	net.Conn
+
+	streamID   string
+	remoteAddr net.Addr
+}
+
+func (c *secureStringMuxConn) StreamID() string {
+	return c.streamID
+}
+
+func (c *secureStringMuxConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// secureConn wraps conn in a DTLS handshake when params.SecurityConfig is
+// set, returning conn unchanged otherwise. On handshake failure conn is left
+// open for the caller to close.
+func secureConn(ctx context.Context, params FactoryParams, conn stringmux.Conn, server bool) (stringmux.Conn, error) {
+	if params.SecurityConfig == nil {
+		return conn, nil
+	}
+
+	dtlsConn, err := params.SecurityConfig.handshake(ctx, conn, server)
+	if err != nil {
+		return nil, errors.Annotate(err, "dtls handshake")
+	}
+
+	return &secureStringMuxConn{
+		Conn:       dtlsConn,
+		streamID:   conn.StreamID(),
+		remoteAddr: conn.RemoteAddr(),
+	}, nil
+}
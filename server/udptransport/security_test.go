@@ -0,0 +1,103 @@
+package udptransport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/stringmux"
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
+)
+
+// fakeStringMuxConn adapts a net.Conn into a stringmux.Conn for tests, the
+// same way secureStringMuxConn adapts a DTLS net.Conn.
+type fakeStringMuxConn struct {
+	net.Conn
+
+	streamID string
+}
+
+func (c *fakeStringMuxConn) StreamID() string {
+	return c.streamID
+}
+
+var _ stringmux.Conn = (*fakeStringMuxConn)(nil)
+
+func TestSecureConn_PassthroughWhenNoSecurityConfig(t *testing.T) {
+	raw, peer := net.Pipe()
+	defer raw.Close()
+	defer peer.Close()
+
+	conn := &fakeStringMuxConn{Conn: raw, streamID: "stream-a"}
+
+	out, err := secureConn(context.Background(), FactoryParams{}, conn, false)
+	if err != nil {
+		t.Fatalf("secureConn: %v", err)
+	}
+
+	if out != conn {
+		t.Fatal("secureConn with a nil SecurityConfig should return conn unchanged")
+	}
+}
+
+func TestSecureConn_Handshake(t *testing.T) {
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatalf("generate self-signed cert: %v", err)
+	}
+
+	security := &SecurityConfig{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	}
+
+	serverRaw, clientRaw := net.Pipe()
+	defer serverRaw.Close()
+	defer clientRaw.Close()
+
+	serverConn := &fakeStringMuxConn{Conn: serverRaw, streamID: "stream-a"}
+	clientConn := &fakeStringMuxConn{Conn: clientRaw, streamID: "stream-a"}
+
+	type result struct {
+		conn stringmux.Conn
+		err  error
+	}
+
+	serverCh := make(chan result, 1)
+
+	go func() {
+		conn, err := secureConn(context.Background(), FactoryParams{SecurityConfig: security}, serverConn, true)
+		serverCh <- result{conn, err}
+	}()
+
+	clientOut, err := secureConn(context.Background(), FactoryParams{SecurityConfig: security}, clientConn, false)
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+
+	var serverRes result
+
+	select {
+	case serverRes = <-serverCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server handshake")
+	}
+
+	if serverRes.err != nil {
+		t.Fatalf("server handshake: %v", serverRes.err)
+	}
+
+	if got := serverRes.conn.StreamID(); got != "stream-a" {
+		t.Fatalf("server conn StreamID() = %q, want %q", got, "stream-a")
+	}
+
+	if got, want := clientOut.StreamID(), "stream-a"; got != want {
+		t.Fatalf("client conn StreamID() = %q, want %q", got, want)
+	}
+
+	if clientOut.RemoteAddr() != clientRaw.RemoteAddr() {
+		t.Fatal("client conn RemoteAddr() should still be the underlying conn's RemoteAddr()")
+	}
+}
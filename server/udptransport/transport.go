@@ -0,0 +1,229 @@
+package udptransport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/peer-calls/peer-calls/server/servertransport"
+	"github.com/peer-calls/peer-calls/server/stringmux"
+	"github.com/pion/sctp"
+)
+
+var _ sctpStream = (*sctp.Stream)(nil)
+
+// finControlFrame and finAckControlFrame are written directly on the
+// metadata/data SCTP streams as part of the graceful close handshake. They
+// are short and distinct enough from regular traffic that a peer running an
+// older version (which never sends finAckControlFrame back) just has its FIN
+// time out, falling back to the abrupt close below.
+const (
+	finControlFrame    = "\x00PC_FIN\x00"
+	finAckControlFrame = "\x00PC_FIN_ACK\x00"
+)
+
+// finLinger bounds how long CloseAsync waits for a FIN_ACK on the metadata
+// and data streams before giving up and closing the association abruptly.
+const finLinger = 5 * time.Second
+
+// deadPeerLinger is used instead of finLinger when a close was triggered by
+// a missed-heartbeat dead-peer detection rather than a normal shutdown: a
+// genuinely dead peer will never send a FIN_ACK, so waiting the full
+// finLinger for one would only delay tearing down the transport and undercut
+// the point of fast dead-peer detection.
+const deadPeerLinger = 250 * time.Millisecond
+
+// Transport wraps a servertransport.Transport together with the SCTP
+// association and stringmux conns it was created from, so the Factory can
+// tear them down when the Transport is closed.
+type Transport struct {
+	*servertransport.Transport
+
+	StreamID string
+
+	log logger.Logger
+
+	association *sctp.Association
+	stringMux   *stringmux.StringMux
+
+	metadataStream *countingStream
+	dataStream     *countingStream
+	mediaConn      *countingConn
+
+	stopHeartbeat     chan struct{}
+	stopHeartbeatOnce sync.Once
+
+	heartbeatMu   sync.RWMutex
+	rtt           time.Duration
+	lastHeartbeat time.Time
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Stats returns a snapshot of SCTP-derived byte/packet counters for this
+// Transport's media, data and metadata conns.
+func (t *Transport) Stats() TransportStats {
+	stats := TransportStats{
+		Data:     t.dataStream.counters.snapshot(),
+		Metadata: t.metadataStream.counters.snapshot(),
+	}
+
+	if t.mediaConn != nil {
+		stats.Media = t.mediaConn.counters.snapshot()
+	}
+
+	return stats
+}
+
+// RTT returns the round-trip time measured from the most recent successful
+// heartbeat, or 0 if no heartbeat has completed yet.
+func (t *Transport) RTT() time.Duration {
+	t.heartbeatMu.RLock()
+	defer t.heartbeatMu.RUnlock()
+
+	return t.rtt
+}
+
+// LastHeartbeat returns the time of the most recent successful heartbeat, or
+// the zero time if no heartbeat has completed yet.
+func (t *Transport) LastHeartbeat() time.Time {
+	t.heartbeatMu.RLock()
+	defer t.heartbeatMu.RUnlock()
+
+	return t.lastHeartbeat
+}
+
+// Close closes the Transport abruptly, without waiting for the remote peer
+// to acknowledge a FIN. Prefer CloseAsync when a graceful shutdown is
+// possible.
+func (t *Transport) Close() error {
+	return errors.Trace(t.closeAbruptly())
+}
+
+// CloseAsync performs a graceful two-phase close: a FIN control frame is
+// written on the metadata and data SCTP streams, reads continue to drain so
+// frames already in flight are not dropped, and the association and
+// underlying stringmux are only torn down once a FIN_ACK has been seen on
+// both streams or finLinger expires. onDone is called exactly once, from a
+// new goroutine, with the error (if any) encountered while closing.
+func (t *Transport) CloseAsync(onDone func(error)) {
+	t.closeAsync(finLinger, onDone)
+}
+
+// closeAsyncDead is like CloseAsync, but uses deadPeerLinger instead of
+// finLinger: it's used when a missed heartbeat has already declared the
+// peer dead, so there is no FIN_ACK worth waiting finLinger for.
+func (t *Transport) closeAsyncDead(onDone func(error)) {
+	t.closeAsync(deadPeerLinger, onDone)
+}
+
+func (t *Transport) closeAsync(linger time.Duration, onDone func(error)) {
+	go func() {
+		onDone(errors.Trace(t.closeGracefully(linger)))
+	}()
+}
+
+func (t *Transport) closeGracefully(linger time.Duration) error {
+	t.stopHeartbeatOnce.Do(func() {
+		close(t.stopHeartbeat)
+	})
+
+	if err := t.sendFIN(t.metadataStream); err != nil {
+		t.log.Warn("Send FIN on metadata stream, closing abruptly", logger.Ctx{
+			"stream_id": t.StreamID,
+			"error":     err,
+		})
+
+		return t.closeAbruptly()
+	}
+
+	if err := t.sendFIN(t.dataStream); err != nil {
+		t.log.Warn("Send FIN on data stream, closing abruptly", logger.Ctx{
+			"stream_id": t.StreamID,
+			"error":     err,
+		})
+
+		return t.closeAbruptly()
+	}
+
+	if !waitFINAcks(t.metadataStream, t.dataStream, linger) {
+		t.log.Warn("Timed out waiting for FIN_ACK", logger.Ctx{
+			"stream_id": t.StreamID,
+		})
+	}
+
+	return t.closeAbruptly()
+}
+
+func (t *Transport) sendFIN(stream *countingStream) error {
+	if stream == nil {
+		return nil
+	}
+
+	_, err := stream.Write([]byte(finControlFrame))
+
+	return errors.Trace(err)
+}
+
+// waitFINAcks blocks until metadataStream's and dataStream's demux
+// goroutines have both observed a FIN_ACK control frame from the peer (see
+// countingStream.handleControlFrame), and reports whether that happened
+// within linger.
+func waitFINAcks(metadataStream, dataStream *countingStream, linger time.Duration) bool {
+	waitCh := make(chan struct{})
+
+	go func() {
+		waitFINAck(metadataStream)
+		waitFINAck(dataStream)
+		close(waitCh)
+	}()
+
+	select {
+	case <-waitCh:
+		return true
+	case <-time.After(linger):
+		return false
+	}
+}
+
+func waitFINAck(stream *countingStream) {
+	if stream == nil {
+		return
+	}
+
+	<-stream.finAckCh
+}
+
+// closeAbruptly tears down the SCTP association and the stringmux conns it
+// was created from, without waiting for the remote peer. It is idempotent.
+func (t *Transport) closeAbruptly() error {
+	t.stopHeartbeatOnce.Do(func() {
+		close(t.stopHeartbeat)
+	})
+
+	t.closeOnce.Do(func() {
+		if t.metadataStream != nil {
+			t.metadataStream.stop()
+		}
+
+		if t.dataStream != nil {
+			t.dataStream.stop()
+		}
+
+		err := errors.Trace(t.Transport.Close())
+
+		if assocErr := t.association.Close(); assocErr != nil && err == nil {
+			err = errors.Trace(assocErr)
+		}
+
+		if muxErr := t.stringMux.Close(); muxErr != nil && err == nil {
+			err = errors.Trace(muxErr)
+		}
+
+		t.closeErr = err
+	})
+
+	return t.closeErr
+}
@@ -0,0 +1,62 @@
+package udptransport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitFINAcks_ReturnsTrueOnceBothAcked(t *testing.T) {
+	metadataStream := newCountingStream(nil)
+	dataStream := newCountingStream(nil)
+
+	close(metadataStream.finAckCh)
+	close(dataStream.finAckCh)
+
+	if ok := waitFINAcks(metadataStream, dataStream, time.Second); !ok {
+		t.Fatal("waitFINAcks returned false despite both streams having been acked")
+	}
+}
+
+// TestWaitFINAcks_DeadPeerLingerIsShort guards against a regression where a
+// heartbeat-triggered close paid the same multi-second finLinger as a
+// normal shutdown: a genuinely dead peer will never send a FIN_ACK, so
+// waitFINAcks must give up well before finLinger when told to use
+// deadPeerLinger.
+func TestWaitFINAcks_DeadPeerLingerIsShort(t *testing.T) {
+	if deadPeerLinger >= finLinger {
+		t.Fatalf("deadPeerLinger (%s) should be much shorter than finLinger (%s)", deadPeerLinger, finLinger)
+	}
+
+	metadataStream := newCountingStream(nil)
+	dataStream := newCountingStream(nil)
+
+	start := time.Now()
+
+	if ok := waitFINAcks(metadataStream, dataStream, deadPeerLinger); ok {
+		t.Fatal("waitFINAcks returned true despite neither stream having been acked")
+	}
+
+	if elapsed := time.Since(start); elapsed >= finLinger {
+		t.Fatalf("waitFINAcks took %s, expected it to give up around deadPeerLinger (%s)", elapsed, deadPeerLinger)
+	}
+}
+
+func TestCountingStream_RespondsToFIN(t *testing.T) {
+	rawA, rawB := newFakeStreamPair()
+
+	a := newCountingStream(rawA)
+	b := newCountingStream(rawB)
+
+	a.start()
+	b.start()
+
+	if _, err := a.Write([]byte(finControlFrame)); err != nil {
+		t.Fatalf("write FIN: %v", err)
+	}
+
+	select {
+	case <-a.finAckCh:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for FIN_ACK in response to FIN")
+	}
+}